@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/ec2"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// awsDiskProvider resizes AWS EBS volumes via ec2.ModifyVolume, waiting for
+// the resulting volume modification to finish applying.
+type awsDiskProvider struct {
+	ec2 *ec2.EC2
+}
+
+func newAWSDiskProvider(region string) (DiskProvider, error) {
+	sess, err := session.NewSession(&aws.Config{Region: aws.String(region)})
+	if err != nil {
+		return nil, err
+	}
+
+	return &awsDiskProvider{ec2: ec2.New(sess)}, nil
+}
+
+// awsRegionFromProviderID extracts the region from a node ProviderID of the
+// form "aws:///<availability-zone>/<instance-id>".
+func awsRegionFromProviderID(uri *url.URL) string {
+	az := strings.Trim(uri.Path, "/")
+	if idx := strings.Index(az, "/"); idx != -1 {
+		az = az[:idx]
+	}
+
+	if az == "" {
+		return ""
+	}
+
+	return az[:len(az)-1]
+}
+
+func (p *awsDiskProvider) Resolve(pv *core_v1.PersistentVolume) (DiskRef, error) {
+	ebs := pv.Spec.AWSElasticBlockStore
+	if ebs == nil {
+		return DiskRef{}, fmt.Errorf("PV %s is not an AWSElasticBlockStore volume", pv.Name)
+	}
+
+	if ebs.Partition != 0 {
+		return DiskRef{}, fmt.Errorf("EBS volume %s has more than one partition", ebs.VolumeID)
+	}
+
+	if ebs.ReadOnly {
+		return DiskRef{}, fmt.Errorf("EBS volume %s is read only", ebs.VolumeID)
+	}
+
+	if pv.Labels == nil {
+		return DiskRef{}, fmt.Errorf("PV %s is missing labels", pv.Name)
+	}
+
+	zone, ok := pv.Labels["failure-domain.beta.kubernetes.io/zone"]
+	if !ok {
+		return DiskRef{}, fmt.Errorf("PV %s missing failure-domain.beta.kubernetes.io/zone label", pv.Name)
+	}
+
+	// VolumeID is reported as "aws://<availability-zone>/<volume-id>";
+	// the EC2 API just wants the volume id.
+	volumeID := ebs.VolumeID
+	if idx := strings.LastIndexByte(volumeID, '/'); idx != -1 {
+		volumeID = volumeID[idx+1:]
+	}
+
+	return DiskRef{
+		Provider: "aws",
+		VolumeID: volumeID,
+		Zone:     zone,
+	}, nil
+}
+
+func (p *awsDiskProvider) Resize(ctx context.Context, ref DiskRef, newSizeGb int64) error {
+	log.Printf("EC2: attempting to describe EBS volume %s", ref.VolumeID)
+
+	out, err := p.ec2.DescribeVolumesWithContext(ctx, &ec2.DescribeVolumesInput{
+		VolumeIds: []*string{aws.String(ref.VolumeID)},
+	})
+	if err != nil {
+		return err
+	}
+	if len(out.Volumes) == 0 {
+		return fmt.Errorf("no EBS volume found for %s", ref.VolumeID)
+	}
+
+	currentSizeGb := aws.Int64Value(out.Volumes[0].Size)
+	log.Printf("EC2: EBS volume %s is %dGb in size", ref.VolumeID, currentSizeGb)
+	if currentSizeGb >= newSizeGb {
+		return nil
+	}
+
+	log.Printf("EC2: attempting to modify EBS volume %s from %dGb to %dGb", ref.VolumeID, currentSizeGb, newSizeGb)
+
+	_, err = p.ec2.ModifyVolumeWithContext(ctx, &ec2.ModifyVolumeInput{
+		VolumeId: aws.String(ref.VolumeID),
+		Size:     aws.Int64(newSizeGb),
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		out, err := p.ec2.DescribeVolumesModificationsWithContext(ctx, &ec2.DescribeVolumesModificationsInput{
+			VolumeIds: []*string{aws.String(ref.VolumeID)},
+		})
+		if err != nil {
+			return err
+		}
+
+		if len(out.VolumesModifications) == 0 {
+			return fmt.Errorf("no volume modification found for EBS volume %s", ref.VolumeID)
+		}
+
+		switch aws.StringValue(out.VolumesModifications[0].ModificationState) {
+		case ec2.VolumeModificationStateCompleted, ec2.VolumeModificationStateOptimizing:
+			log.Printf("EC2: EBS volume %s resized", ref.VolumeID)
+			return nil
+		case ec2.VolumeModificationStateFailed:
+			return fmt.Errorf("EBS volume %s modification failed", ref.VolumeID)
+		}
+
+		time.Sleep(10 * time.Second)
+	}
+}
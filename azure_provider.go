@@ -0,0 +1,108 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	azure_compute "github.com/Azure/azure-sdk-for-go/services/compute/mgmt/2021-04-01/compute"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// azureSubscriptionID/azureResourceGroup identify the scope the Azure SDK
+// looks up managed disks in. Azure's ProviderID doesn't carry the
+// resource group, so these have to come from the environment the sidecar
+// is deployed in, same as its credentials.
+var (
+	azureSubscriptionID = envOrEmpty("AZURE_SUBSCRIPTION_ID")
+	azureResourceGroup  = envOrEmpty("AZURE_RESOURCE_GROUP")
+)
+
+// azureDiskProvider resizes Azure Managed Disks via the Compute Resource
+// Provider's disks client.
+type azureDiskProvider struct {
+	disks         azure_compute.DisksClient
+	resourceGroup string
+}
+
+func newAzureDiskProvider(subscriptionID, resourceGroup string) (DiskProvider, error) {
+	if subscriptionID == "" {
+		return nil, fmt.Errorf("AZURE_SUBSCRIPTION_ID must be set to use the azure disk provider")
+	}
+	if resourceGroup == "" {
+		return nil, fmt.Errorf("AZURE_RESOURCE_GROUP must be set to use the azure disk provider")
+	}
+
+	authorizer, err := auth.NewAuthorizerFromEnvironment()
+	if err != nil {
+		return nil, err
+	}
+
+	disksClient := azure_compute.NewDisksClient(subscriptionID)
+	disksClient.Authorizer = authorizer
+
+	return &azureDiskProvider{
+		disks:         disksClient,
+		resourceGroup: resourceGroup,
+	}, nil
+}
+
+func (p *azureDiskProvider) Resolve(pv *core_v1.PersistentVolume) (DiskRef, error) {
+	azureDisk := pv.Spec.AzureDisk
+	if azureDisk == nil {
+		return DiskRef{}, fmt.Errorf("PV %s is not an AzureDisk volume", pv.Name)
+	}
+
+	if azureDisk.ReadOnly != nil && *azureDisk.ReadOnly {
+		return DiskRef{}, fmt.Errorf("Azure disk %s is read only", azureDisk.DiskName)
+	}
+
+	if pv.Labels == nil {
+		return DiskRef{}, fmt.Errorf("PV %s is missing labels", pv.Name)
+	}
+
+	zone, ok := pv.Labels["failure-domain.beta.kubernetes.io/zone"]
+	if !ok {
+		return DiskRef{}, fmt.Errorf("PV %s missing failure-domain.beta.kubernetes.io/zone label", pv.Name)
+	}
+
+	return DiskRef{
+		Provider: "azure",
+		VolumeID: azureDisk.DiskName,
+		Zone:     zone,
+	}, nil
+}
+
+func (p *azureDiskProvider) Resize(ctx context.Context, ref DiskRef, newSizeGb int64) error {
+	log.Printf("Azure: attempting to get managed disk %s in resource group %s", ref.VolumeID, p.resourceGroup)
+	disk, err := p.disks.Get(ctx, p.resourceGroup, ref.VolumeID)
+	if err != nil {
+		return err
+	}
+
+	if disk.DiskProperties != nil && disk.DiskProperties.DiskSizeGB != nil &&
+		int64(*disk.DiskProperties.DiskSizeGB) >= newSizeGb {
+		return nil
+	}
+
+	log.Printf("Azure: attempting to resize managed disk %s to %dGb", ref.VolumeID, newSizeGb)
+
+	size32 := int32(newSizeGb)
+	future, err := p.disks.Update(ctx, p.resourceGroup, ref.VolumeID, azure_compute.DiskUpdate{
+		DiskUpdateProperties: &azure_compute.DiskUpdateProperties{
+			DiskSizeGB: &size32,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	if err := future.WaitForCompletionRef(ctx, p.disks.Client); err != nil {
+		return err
+	}
+
+	log.Printf("Azure: managed disk %s resized", ref.VolumeID)
+	return nil
+}
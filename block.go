@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+)
+
+// serveBlockVolumeSignals listens on a Unix socket dedicated to volume,
+// under socketDir, until ctx is cancelled. volumeMode: Block volumes have
+// no filesystem for the sidecar to Statfs, so the application is expected
+// to connect to this socket and write a line whenever it judges the
+// volume to be running low, which triggers an immediate disk expansion.
+func serveBlockVolumeSignals(ctx context.Context, socketDir string, volume mountedVolume) error {
+	if err := os.MkdirAll(socketDir, 0755); err != nil {
+		return err
+	}
+
+	socketPath := filepath.Join(socketDir, volume.Name+".sock")
+	os.Remove(socketPath) // stale socket from a previous run, ignore if absent
+
+	listener, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return err
+	}
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	log.Printf("volume %s: listening for block device expansion signals on %s", volume.Name, socketPath)
+
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+
+		go handleBlockVolumeSignal(ctx, conn, volume)
+	}
+}
+
+func handleBlockVolumeSignal(ctx context.Context, conn net.Conn, volume mountedVolume) {
+	defer conn.Close()
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		log.Printf("volume %s: received block device expansion signal %q", volume.Name, scanner.Text())
+
+		if err := resizeDisk(ctx, volume); err != nil {
+			log.Printf("volume %s: %v", volume.Name, err)
+		}
+	}
+}
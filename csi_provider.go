@@ -0,0 +1,69 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	core_v1 "k8s.io/api/core/v1"
+	resource "k8s.io/apimachinery/pkg/api/resource"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// csiDiskProvider handles PVs backed by a CSI driver whose StorageClass
+// sets allowVolumeExpansion: true. Rather than calling a cloud API
+// directly, it patches the PVC's requested storage size and leaves the
+// external-resizer sidecar and kubelet to carry out the actual expansion.
+type csiDiskProvider struct {
+	clientset *kubernetes.Clientset
+}
+
+func newCSIDiskProvider(clientset *kubernetes.Clientset) DiskProvider {
+	return &csiDiskProvider{clientset: clientset}
+}
+
+func (p *csiDiskProvider) Resolve(pv *core_v1.PersistentVolume) (DiskRef, error) {
+	csi := pv.Spec.CSI
+	if csi == nil {
+		return DiskRef{}, fmt.Errorf("PV %s is not a CSI volume", pv.Name)
+	}
+
+	if pv.Spec.ClaimRef == nil {
+		return DiskRef{}, fmt.Errorf("PV %s has no claimRef, cannot patch its PersistentVolumeClaim", pv.Name)
+	}
+
+	return DiskRef{
+		Provider:     "csi",
+		VolumeID:     csi.VolumeHandle,
+		PVCNamespace: pv.Spec.ClaimRef.Namespace,
+		PVCName:      pv.Spec.ClaimRef.Name,
+	}, nil
+}
+
+func (p *csiDiskProvider) Resize(ctx context.Context, ref DiskRef, newSizeGb int64) error {
+	if ref.PVCName == "" {
+		return fmt.Errorf("csiDiskProvider: DiskRef is missing a PersistentVolumeClaim to patch")
+	}
+
+	pvc, err := p.clientset.Core().PersistentVolumeClaims(ref.PVCNamespace).Get(ref.PVCName, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	newSize := resource.MustParse(fmt.Sprintf("%dGi", newSizeGb))
+	if existing, ok := pvc.Spec.Resources.Requests[core_v1.ResourceStorage]; ok && existing.Cmp(newSize) >= 0 {
+		return nil
+	}
+
+	log.Printf("CSI: attempting to patch PVC %s/%s storage request to %dGi", ref.PVCNamespace, ref.PVCName, newSizeGb)
+
+	pvc.Spec.Resources.Requests[core_v1.ResourceStorage] = newSize
+	_, err = p.clientset.Core().PersistentVolumeClaims(ref.PVCNamespace).Update(pvc)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("CSI: PVC %s/%s patched, external-resizer will complete the expansion", ref.PVCNamespace, ref.PVCName)
+	return nil
+}
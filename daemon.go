@@ -0,0 +1,264 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/informers"
+	"k8s.io/client-go/kubernetes"
+	core_v1listers "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+const (
+	// autoExpandAnnotation opts a PVC into daemonset mode, in place of
+	// wiring the sidecar into the workload's pod spec via -pod-name,
+	// -container-name and -volumes.
+	autoExpandAnnotation = "sidecar.hpidcock.io/autoexpand"
+
+	// ownerNodeAnnotation/ownerLeaseAnnotation implement a lightweight
+	// leader election for a PVC: whichever daemon last renewed the lease
+	// is the one responsible for watching and expanding it.
+	ownerNodeAnnotation  = "sidecar.hpidcock.io/owner-node"
+	ownerLeaseAnnotation = "sidecar.hpidcock.io/owner-lease"
+
+	ownerLeaseDuration = 2 * time.Minute
+
+	// informerResync is how often the informer caches below do a full
+	// relist against the API server to correct for any watch events
+	// missed; between relists they're updated from the watch stream.
+	informerResync = 10 * time.Minute
+)
+
+var (
+	runMode  string
+	nodeName string
+)
+
+// runDaemonSetController runs the sidecar as a single cluster-wide
+// controller instead of being injected into every workload's pod spec. One
+// instance runs per node (as a DaemonSet); together they manage every PVC
+// annotated with sidecar.hpidcock.io/autoexpand=true, discovering mounts on
+// their own node directly and leaving PVCs mounted elsewhere to the daemon
+// running on that node. This removes the need to add the sidecar container
+// and its pod-scoped RBAC to every workload.
+func runDaemonSetController(ctx context.Context, clientset *kubernetes.Clientset, cloudProvider DiskProvider) error {
+	if nodeName == "" {
+		return fmt.Errorf("-node-name (or the NODE_NAME env var) must be set in daemonset mode")
+	}
+
+	csiProvider := newCSIDiskProvider(clientset)
+
+	// Informers give every node's daemon a locally-cached, watch-fed view
+	// of Pods and PersistentVolumeClaims instead of each one doing a full
+	// List of both across the whole cluster every pollPeriod.
+	informerFactory := informers.NewSharedInformerFactory(clientset, informerResync)
+	pvcLister := informerFactory.Core().V1().PersistentVolumeClaims().Lister()
+	podLister := informerFactory.Core().V1().Pods().Lister()
+
+	stopCh := ctx.Done()
+	informerFactory.Start(stopCh)
+	if !cache.WaitForCacheSync(stopCh, informerFactory.Core().V1().PersistentVolumeClaims().Informer().HasSynced,
+		informerFactory.Core().V1().Pods().Informer().HasSynced) {
+		return fmt.Errorf("daemonset: timed out waiting for Pod/PersistentVolumeClaim informer caches to sync")
+	}
+
+	log.Printf("daemonset controller starting on node %s", nodeName)
+	for {
+		reconcileAutoExpandPVCs(ctx, clientset, cloudProvider, csiProvider, pvcLister, podLister)
+		time.Sleep(pollPeriod)
+	}
+}
+
+// reconcileAutoExpandPVCs lists every PVC in the cluster from the local
+// informer cache, since annotations aren't selectable server-side, and
+// reconciles the ones opted into daemonset mode.
+func reconcileAutoExpandPVCs(ctx context.Context, clientset *kubernetes.Clientset, cloudProvider, csiProvider DiskProvider, pvcLister core_v1listers.PersistentVolumeClaimLister, podLister core_v1listers.PodLister) {
+	pvcs, err := pvcLister.List(labels.Everything())
+	if err != nil {
+		log.Printf("daemonset: could not list PersistentVolumeClaims: %v", err)
+		return
+	}
+
+	for _, pvc := range pvcs {
+		if pvc.Annotations[autoExpandAnnotation] != "true" {
+			continue
+		}
+
+		if err := reconcilePVC(ctx, clientset, cloudProvider, csiProvider, podLister, pvc); err != nil {
+			log.Printf("daemonset: volume claim %s/%s: %v", pvc.Namespace, pvc.Name, err)
+		}
+	}
+}
+
+// reconcilePVC resolves pvc down to a mountedVolume, if it is currently
+// mounted by a pod on this node, and runs it through the same
+// checkFilesystemUsage path the sidecar mode uses. Volumes mounted on other
+// nodes, or not mounted anywhere, are left alone; another daemon (or
+// nobody, yet) is responsible for them.
+func reconcilePVC(ctx context.Context, clientset *kubernetes.Clientset, cloudProvider, csiProvider DiskProvider, podLister core_v1listers.PodLister, pvc *core_v1.PersistentVolumeClaim) error {
+	if pvc.Status.Phase != core_v1.ClaimBound {
+		return nil
+	}
+
+	pod, container, mountPath, err := findMountingPod(podLister, pvc)
+	if err != nil {
+		return err
+	}
+
+	if pod == nil || pod.Spec.NodeName != nodeName {
+		return nil // not mounted here; another node's daemon is responsible
+	}
+
+	if !claimOwnership(clientset, pvc) {
+		return nil // another daemon's lease on this PVC is still live
+	}
+
+	pv, err := clientset.Core().PersistentVolumes().Get(pvc.Spec.VolumeName, meta_v1.GetOptions{})
+	if err != nil {
+		return err
+	}
+
+	if pv.Status.Phase != core_v1.VolumeBound {
+		return fmt.Errorf("PV %s phase is not Bound, instead %s", pv.Name, pv.Status.Phase)
+	}
+
+	provider, err := selectProvider(clientset, cloudProvider, csiProvider, pv)
+	if err != nil {
+		return err
+	}
+
+	ref, err := provider.Resolve(pv)
+	if err != nil {
+		return err
+	}
+
+	pid, err := findContainerPID(containerIDFor(pod, container))
+	if err != nil {
+		return err
+	}
+
+	devicePath, err := deviceForMountPath(pid, mountPath)
+	if err != nil {
+		return err
+	}
+
+	fsType := declaredFSType(pv)
+	if fsType == "" {
+		fsType, err = detectFilesystemType(devicePath)
+		if err != nil {
+			return fmt.Errorf("could not detect filesystem type: %v", err)
+		}
+	}
+
+	if !isSupportedFSType(fsType) {
+		return fmt.Errorf("unsupported filesystem type %q", fsType)
+	}
+
+	volume := mountedVolume{
+		Name: pvc.Name,
+		// mountPath is relative to the workload container's own mount
+		// namespace; translate it through /proc/<pid>/root so Statfs and
+		// the resize2fs/xfs_growfs/btrfs calls below hit the real volume
+		// instead of whatever happens to live at that path in the
+		// daemon's own root.
+		MountedPath:  hostMountPath(pid, mountPath),
+		DevicePath:   devicePath,
+		FSType:       fsType,
+		VolumeMode:   core_v1.PersistentVolumeFilesystem,
+		PVCNamespace: pvc.Namespace,
+		PVCName:      pvc.Name,
+		Ref:          ref,
+		Provider:     provider,
+	}
+
+	// checkFilesystemUsage/resizeFilesystem/resizeDisk record events against
+	// the single "parent pod" they were originally built to watch. In
+	// daemonset mode that target changes every reconcile, one pod at a
+	// time, so point it at the pod we just resolved before calling through
+	// to the same shared logic the sidecar uses.
+	namespace = pod.Namespace
+	parentPod = pod
+
+	return checkFilesystemUsage(ctx, volume)
+}
+
+// findMountingPod looks for a pod in pvc's namespace that currently mounts
+// it, returning the pod, the container mounting it and the in-container
+// mount path. It returns a nil pod if no pod currently mounts the PVC. Pods
+// are read from the local informer cache rather than listed from the API
+// server on every call.
+func findMountingPod(podLister core_v1listers.PodLister, pvc *core_v1.PersistentVolumeClaim) (*core_v1.Pod, *core_v1.Container, string, error) {
+	pods, err := podLister.Pods(pvc.Namespace).List(labels.Everything())
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	for _, pod := range pods {
+		for _, v := range pod.Spec.Volumes {
+			if v.PersistentVolumeClaim == nil || v.PersistentVolumeClaim.ClaimName != pvc.Name {
+				continue
+			}
+
+			for ci := range pod.Spec.Containers {
+				container := &pod.Spec.Containers[ci]
+				for _, vm := range container.VolumeMounts {
+					if vm.Name == v.Name {
+						return pod, container, vm.MountPath, nil
+					}
+				}
+			}
+		}
+	}
+
+	return nil, nil, "", nil
+}
+
+// containerIDFor returns the CRI-style container ID (e.g.
+// "containerd://...") that the kubelet reports for container within pod.
+func containerIDFor(pod *core_v1.Pod, container *core_v1.Container) string {
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.Name == container.Name {
+			return status.ContainerID
+		}
+	}
+	return ""
+}
+
+// claimOwnership attempts to record this node as responsible for expanding
+// pvc, so only one daemon in the cluster acts on a given volume at a time.
+// The "lease" is a timestamped pair of annotations rather than a separate
+// Lease object: any daemon can take over once it goes stale, which handles
+// a dead or partitioned node without needing a dedicated election library.
+func claimOwnership(clientset *kubernetes.Clientset, pvc *core_v1.PersistentVolumeClaim) bool {
+	now := time.Now()
+
+	if owner, ok := pvc.Annotations[ownerNodeAnnotation]; ok && owner != nodeName {
+		if leaseStr, ok := pvc.Annotations[ownerLeaseAnnotation]; ok {
+			if lease, err := time.Parse(time.RFC3339, leaseStr); err == nil && now.Sub(lease) < ownerLeaseDuration {
+				return false
+			}
+		}
+	}
+
+	// pvc comes from the shared informer cache; copy it before mutating so
+	// we don't corrupt the cache's copy out from under other readers.
+	pvc = pvc.DeepCopy()
+	if pvc.Annotations == nil {
+		pvc.Annotations = map[string]string{}
+	}
+	pvc.Annotations[ownerNodeAnnotation] = nodeName
+	pvc.Annotations[ownerLeaseAnnotation] = now.Format(time.RFC3339)
+
+	if _, err := clientset.Core().PersistentVolumeClaims(pvc.Namespace).Update(pvc); err != nil {
+		log.Printf("daemonset: volume claim %s/%s: could not claim ownership: %v", pvc.Namespace, pvc.Name, err)
+		return false
+	}
+
+	return true
+}
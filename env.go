@@ -0,0 +1,9 @@
+package main
+
+import "os"
+
+// envOrEmpty returns the value of the named environment variable, or "" if
+// it is unset.
+func envOrEmpty(name string) string {
+	return os.Getenv(name)
+}
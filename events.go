@@ -0,0 +1,52 @@
+package main
+
+import (
+	"log"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// eventsClientset/parentPod are used by recordPodEvent, following the same
+// per-process-singleton convention as the namespace/podName/containerName
+// flags. main() sets eventsClientset, and in sidecar mode sets parentPod
+// once; in daemonset mode reconcilePVC repoints parentPod (and namespace)
+// at the pod mounting each PVC it reconciles.
+var (
+	eventsClientset *kubernetes.Clientset
+	parentPod       *core_v1.Pod
+)
+
+// recordPodEvent emits a Kubernetes Event against the parent pod, so
+// operators can see expansion history with `kubectl describe pod` without
+// reading the sidecar's logs.
+func recordPodEvent(eventType, reason, message string) {
+	if eventsClientset == nil || parentPod == nil {
+		return
+	}
+
+	event := &core_v1.Event{
+		ObjectMeta: meta_v1.ObjectMeta{
+			GenerateName: "gke-scale-disk-sidecar-",
+			Namespace:    namespace,
+		},
+		InvolvedObject: core_v1.ObjectReference{
+			Kind:      "Pod",
+			Name:      parentPod.Name,
+			Namespace: namespace,
+			UID:       parentPod.UID,
+		},
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         core_v1.EventSource{Component: "gke-scale-disk-sidecar"},
+		FirstTimestamp: meta_v1.Now(),
+		LastTimestamp:  meta_v1.Now(),
+		Count:          1,
+	}
+
+	if _, err := eventsClientset.Core().Events(namespace).Create(event); err != nil {
+		log.Printf("failed to record event %s: %v", reason, err)
+	}
+}
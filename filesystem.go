@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// detectFilesystemType runs blkid against devicePath to determine what
+// filesystem it currently holds, e.g. "ext4", "xfs", "btrfs".
+func detectFilesystemType(devicePath string) (string, error) {
+	out, err := runCommand("blkid", "-o", "value", "-s", "TYPE", devicePath)
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSpace(out), nil
+}
+
+// declaredFSType returns the FSType a PV's volume source itself declares,
+// if any, so getMountedVolumes can skip the blkid probe when it's set.
+func declaredFSType(pv *core_v1.PersistentVolume) string {
+	switch {
+	case pv.Spec.GCEPersistentDisk != nil:
+		return pv.Spec.GCEPersistentDisk.FSType
+	case pv.Spec.AWSElasticBlockStore != nil:
+		return pv.Spec.AWSElasticBlockStore.FSType
+	case pv.Spec.AzureDisk != nil && pv.Spec.AzureDisk.FSType != nil:
+		return *pv.Spec.AzureDisk.FSType
+	default:
+		return ""
+	}
+}
+
+// isSupportedFSType reports whether fsType is one the sidecar knows how to
+// grow in place.
+func isSupportedFSType(fsType string) bool {
+	switch fsType {
+	case "ext2", "ext3", "ext4", "xfs", "btrfs":
+		return true
+	default:
+		return false
+	}
+}
+
+// resizeFilesystem grows volume's filesystem to fill its partition, using
+// whichever tool matches its FSType, and records the outcome as a metric
+// and a Kubernetes Event on the parent pod.
+func resizeFilesystem(volume mountedVolume) error {
+	err := growFilesystem(volume)
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	fsResizeTotal.WithLabelValues(volume.key(), result).Inc()
+
+	if err != nil {
+		recordPodEvent(core_v1.EventTypeWarning, "ExpansionFailed", fmt.Sprintf("volume %s: failed to resize filesystem: %v", volume.Name, err))
+		return err
+	}
+
+	recordPodEvent(core_v1.EventTypeNormal, "FilesystemResized", fmt.Sprintf("volume %s: filesystem resized to fill partition", volume.Name))
+	return nil
+}
+
+func growFilesystem(volume mountedVolume) error {
+	switch volume.FSType {
+	case "", "ext2", "ext3", "ext4":
+		_, err := runCommand("resize2fs", volume.DevicePath)
+		return err
+	case "xfs":
+		_, err := runCommand("xfs_growfs", volume.MountedPath)
+		return err
+	case "btrfs":
+		_, err := runCommand("btrfs", "filesystem", "resize", "max", volume.MountedPath)
+		return err
+	default:
+		return fmt.Errorf("volume %s: cannot grow unsupported filesystem type %q", volume.Name, volume.FSType)
+	}
+}
+
+// blockDeviceSizeBytes reads the current size of a raw block device. It
+// stands in for syscall.Statfs on volumeMode: Block volumes, which have no
+// filesystem for the sidecar to inspect.
+func blockDeviceSizeBytes(devicePath string) (int64, error) {
+	out, err := runCommand("blockdev", "--getsize64", devicePath)
+	if err != nil {
+		return 0, err
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("could not parse blockdev output %q: %v", out, err)
+	}
+
+	return size, nil
+}
+
+// runCommand runs name with args and returns its captured stdout.
+func runCommand(name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+
+	var out bytes.Buffer
+	cmd.Stdout = &out
+
+	if err := cmd.Run(); err != nil {
+		return "", err
+	}
+
+	return out.String(), nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	multierror "github.com/hashicorp/go-multierror"
+	google_oauth "golang.org/x/oauth2/google"
+	google_compute "google.golang.org/api/compute/v1"
+
+	core_v1 "k8s.io/api/core/v1"
+)
+
+// gceDiskProvider resizes GCE Persistent Disks via the Compute Engine API.
+type gceDiskProvider struct {
+	disks     *google_compute.DisksService
+	zoneOps   *google_compute.ZoneOperationsService
+	projectID string
+}
+
+func newGCEDiskProvider(ctx context.Context, projectID string) (DiskProvider, error) {
+	client, err := google_oauth.DefaultClient(ctx, google_compute.ComputeScope)
+	if err != nil {
+		return nil, err
+	}
+
+	computeService, err := google_compute.New(client)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gceDiskProvider{
+		disks:     google_compute.NewDisksService(computeService),
+		zoneOps:   google_compute.NewZoneOperationsService(computeService),
+		projectID: projectID,
+	}, nil
+}
+
+func (p *gceDiskProvider) Resolve(pv *core_v1.PersistentVolume) (DiskRef, error) {
+	pd := pv.Spec.GCEPersistentDisk
+	if pd == nil {
+		return DiskRef{}, fmt.Errorf("PV %s is not a GCEPersistentDisk", pv.Name)
+	}
+
+	if pd.Partition != 0 {
+		return DiskRef{}, fmt.Errorf("PD %s has more than one parition", pd.PDName)
+	}
+
+	if pd.ReadOnly {
+		return DiskRef{}, fmt.Errorf("PD %s is read only", pd.PDName)
+	}
+
+	if pv.Labels == nil {
+		return DiskRef{}, fmt.Errorf("PV %s is missing labels", pv.Name)
+	}
+
+	region, ok := pv.Labels["failure-domain.beta.kubernetes.io/region"]
+	if !ok {
+		return DiskRef{}, fmt.Errorf("PV %s missing failure-domain.beta.kubernetes.io/region label", pv.Name)
+	}
+
+	zone, ok := pv.Labels["failure-domain.beta.kubernetes.io/zone"]
+	if !ok {
+		return DiskRef{}, fmt.Errorf("PV %s missing failure-domain.beta.kubernetes.io/zone label", pv.Name)
+	}
+
+	return DiskRef{
+		Provider: "gce",
+		VolumeID: pd.PDName,
+		Region:   region,
+		Zone:     zone,
+	}, nil
+}
+
+func (p *gceDiskProvider) Resize(ctx context.Context, ref DiskRef, newSizeGb int64) error {
+	log.Printf("DisksService: attempting to get PD %s in zone %s project %s", ref.VolumeID, ref.Zone, p.projectID)
+	disk, err := p.disks.Get(p.projectID, ref.Zone, ref.VolumeID).Do()
+	if err != nil {
+		return err
+	}
+
+	log.Printf("DisksService: PD %s is %dGb in size", ref.VolumeID, disk.SizeGb)
+	if disk.SizeGb >= newSizeGb {
+		return nil
+	}
+
+	log.Printf("DisksService: attempting to resize PD %s from %dGb to %dGb", ref.VolumeID, disk.SizeGb, newSizeGb)
+
+	resizeReq := google_compute.DisksResizeRequest{
+		SizeGb: newSizeGb,
+	}
+	op, err := p.disks.Resize(p.projectID, ref.Zone, ref.VolumeID, &resizeReq).Do()
+	if err != nil {
+		return err
+	}
+
+	if op == nil {
+		return fmt.Errorf("nil operation returned by GCEDisksService")
+	}
+
+	for op.Status != "DONE" || op.Error != nil {
+		time.Sleep(30 * time.Second)
+
+		op, err = p.zoneOps.Get(p.projectID, ref.Zone, op.Name).Do()
+		if err != nil {
+			return err
+		}
+
+		if op == nil {
+			return fmt.Errorf("nil operation returned by GCEZoneOperationsService")
+		}
+	}
+
+	if op.Error != nil {
+		merr := &multierror.Error{}
+		for _, v := range op.Error.Errors {
+			if v == nil {
+				continue
+			}
+
+			merr = multierror.Append(merr, errors.New(v.Message))
+		}
+
+		return multierror.Flatten(merr)
+	}
+
+	log.Printf("DisksService: PD %s resized", ref.VolumeID)
+	return nil
+}
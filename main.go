@@ -16,10 +16,6 @@ import (
 	"syscall"
 	"time"
 
-	multierror "github.com/hashicorp/go-multierror"
-	google_oauth "golang.org/x/oauth2/google"
-	google_compute "google.golang.org/api/compute/v1"
-
 	core_v1 "k8s.io/api/core/v1"
 	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
@@ -27,27 +23,39 @@ import (
 )
 
 var (
-	projectID     string
-	namespace     string
-	containerName string
-	podName       string
-	volumeString  string
-	threshold     int
-	expandBy      int
-	pollPeriod    time.Duration
-
-	computeService        *google_compute.Service
-	diskService           *google_compute.DisksService
-	zoneOperationsService *google_compute.ZoneOperationsService
+	namespace      string
+	containerName  string
+	podName        string
+	volumeString   string
+	threshold      int
+	expandBy       int
+	pollPeriod     time.Duration
+	blockSignalDir string
+	metricsAddr    string
 )
 
-type mountedGCEVolume struct {
-	Name        string
-	MountedPath string
-	DevicePath  string
-	PDName      string
-	GCPRegion   string
-	GCPZone     string
+// mountedVolume is a volume mounted into the sidecar's container that has
+// been resolved down to the cloud disk (or CSI volume) backing it, and the
+// DiskProvider that knows how to grow that disk.
+type mountedVolume struct {
+	Name         string
+	MountedPath  string
+	DevicePath   string
+	FSType       string
+	VolumeMode   core_v1.PersistentVolumeMode
+	PVCNamespace string
+	PVCName      string
+	Ref          DiskRef
+	Provider     DiskProvider
+}
+
+// key identifies volume for per-volume in-memory state (expansion budgets,
+// predictive trends) and Prometheus label values. Name alone is only unique
+// within one pod's volumes; PVCNamespace/PVCName is what's actually unique
+// cluster-wide, which matters once the daemonset controller (chunk0-6) has
+// one process reconciling PVCs from every namespace at once.
+func (v mountedVolume) key() string {
+	return v.PVCNamespace + "/" + v.PVCName
 }
 
 func main() {
@@ -60,40 +68,63 @@ func main() {
 	flag.IntVar(&threshold, "threshold", 80, "usage percentage threshold on a volume to trigger expansion")
 	flag.IntVar(&expandBy, "expand-by", 20, "percentage of current volume size to add when expansion is triggered")
 	flag.DurationVar(&pollPeriod, "poll-period", 60*time.Second, "period between each poll of disk status")
+	flag.StringVar(&blockSignalDir, "block-signal-dir", "/var/run/gke-scale-disk-sidecar", "directory holding a unix socket per volumeMode: Block volume, which the application writes to in order to request expansion")
+	flag.StringVar(&metricsAddr, "metrics-addr", ":9102", "address to serve Prometheus metrics on")
+	flag.Int64Var(&maxSizeGb, "max-size-gb", 0, "absolute ceiling in GB a volume's disk may be expanded to; 0 means unlimited")
+	flag.DurationVar(&minExpansionInterval, "min-interval", 10*time.Minute, "minimum time between two successive expansions of the same disk")
+	flag.IntVar(&maxExpansionsPerDay, "max-expansions-per-day", 6, "maximum number of expansions allowed per volume in a rolling 24h window; 0 means unlimited")
+	flag.StringVar(&mode, "mode", "threshold", "expansion trigger mode: \"threshold\" or \"predictive\"")
+	flag.DurationVar(&predictiveLeadTime, "lead-time", 10*time.Minute, "predictive mode: trigger expansion when a volume is projected to fill within this horizon")
+	flag.IntVar(&predictiveSamples, "predictive-samples", 60, "predictive mode: number of samples kept for the free-space trend regression")
+	flag.StringVar(&runMode, "run-mode", "sidecar", "\"sidecar\": manage the volumes of one pod, wired in via -pod-name/-container-name/-volumes; \"daemonset\": run cluster-wide, managing every PersistentVolumeClaim annotated with sidecar.hpidcock.io/autoexpand=true")
+	flag.StringVar(&nodeName, "node-name", os.Getenv("NODE_NAME"), "node this instance is running on, used in daemonset mode to find locally mounted volumes and elect a single owner per volume")
 
 	flag.Parse()
-	if flag.Parsed() == false ||
-		containerName == "" ||
-		podName == "" ||
-		namespace == "" ||
-		volumeString == "" {
-		flag.PrintDefaults()
-		return
-	}
 
-	volumes := strings.Split(volumeString, ",")
+	go serveMetrics(metricsAddr)
 
-	client, err := google_oauth.DefaultClient(ctx, google_compute.ComputeScope)
+	config, err := rest.InClusterConfig()
 	if err != nil {
 		log.Fatal(err)
 	}
-	computeService, err = google_compute.New(client)
+
+	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
 		log.Fatal(err)
 	}
-	diskService = google_compute.NewDisksService(computeService)
-	zoneOperationsService = google_compute.NewZoneOperationsService(computeService)
 
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		log.Fatal(err)
+	if runMode == "daemonset" {
+		eventsClientset = clientset
+
+		node, err := clientset.Core().Nodes().Get(nodeName, meta_v1.GetOptions{})
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		uri, err := url.Parse(node.Spec.ProviderID)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		cloudProvider, err := cloudProviderForNode(ctx, uri)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		log.Fatal(runDaemonSetController(ctx, clientset, cloudProvider))
 	}
 
-	clientset, err := kubernetes.NewForConfig(config)
-	if err != nil {
-		log.Fatal(err)
+	if flag.Parsed() == false ||
+		containerName == "" ||
+		podName == "" ||
+		namespace == "" ||
+		volumeString == "" {
+		flag.PrintDefaults()
+		return
 	}
 
+	volumes := strings.Split(volumeString, ",")
+
 	pod, err := clientset.Core().Pods(namespace).Get(podName, meta_v1.GetOptions{})
 	if err != nil {
 		log.Fatal(err)
@@ -104,6 +135,9 @@ func main() {
 	}
 	log.Printf("found pod %s", pod.Name)
 
+	eventsClientset = clientset
+	parentPod = pod
+
 	node, err := clientset.Core().Nodes().Get(pod.Spec.NodeName, meta_v1.GetOptions{})
 	if err != nil {
 		log.Fatal(err)
@@ -119,12 +153,13 @@ func main() {
 		log.Fatal(err)
 	}
 
-	if uri.Scheme != "gce" {
-		log.Fatal("pod running on a non-GKE node")
-	}
+	log.Printf("node's cloud provider scheme is %s", uri.Scheme)
 
-	projectID = uri.Host
-	log.Printf("GCP ProjectID is %s", projectID)
+	cloudProvider, err := cloudProviderForNode(ctx, uri)
+	if err != nil {
+		log.Fatal(err)
+	}
+	csiProvider := newCSIDiskProvider(clientset)
 
 	container, err := findContainer(pod, containerName)
 	if err != nil {
@@ -133,23 +168,43 @@ func main() {
 
 	log.Printf("found container %s", container.Name)
 
-	gceVolumes, err := getMountedVolumes(pod, container, volumes, clientset)
+	mountedVolumes, err := getMountedVolumes(pod, container, volumes, clientset, cloudProvider, csiProvider)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	if len(gceVolumes) != len(volumes) {
+	if len(mountedVolumes) != len(volumes) {
 		log.Fatal("was not able to map all volumes")
 	}
 
-	for _, volume := range gceVolumes {
-		log.Printf("volume %s: GCE PD %s attached as %s mounted to %s", volume.Name, volume.PDName, volume.DevicePath, volume.MountedPath)
+	for _, volume := range mountedVolumes {
+		if volume.VolumeMode == core_v1.PersistentVolumeBlock {
+			log.Printf("volume %s: %s disk %s attached as raw block device %s", volume.Name, volume.Ref.Provider, volume.Ref.VolumeID, volume.DevicePath)
+			continue
+		}
+		log.Printf("volume %s: %s disk %s attached as %s mounted to %s (%s)", volume.Name, volume.Ref.Provider, volume.Ref.VolumeID, volume.DevicePath, volume.MountedPath, volume.FSType)
+	}
+
+	for _, volume := range mountedVolumes {
+		if volume.VolumeMode != core_v1.PersistentVolumeBlock {
+			continue
+		}
+
+		go func(volume mountedVolume) {
+			if err := serveBlockVolumeSignals(ctx, blockSignalDir, volume); err != nil {
+				log.Printf("volume %s: %v", volume.Name, err)
+			}
+		}(volume)
 	}
 
 	log.Print("starting volume monitor loop")
 	for {
-		for _, volume := range gceVolumes {
-			err := checkFilesystemUsage(volume)
+		for _, volume := range mountedVolumes {
+			if volume.VolumeMode == core_v1.PersistentVolumeBlock {
+				continue // expansion is driven by serveBlockVolumeSignals instead
+			}
+
+			err := checkFilesystemUsage(ctx, volume)
 			if err != nil {
 				log.Printf("volume %s: %v", volume.Name, err) // Non-fatal, try again next loop
 			}
@@ -158,17 +213,29 @@ func main() {
 	}
 }
 
-func checkFilesystemUsage(volume mountedGCEVolume) error {
+func checkFilesystemUsage(ctx context.Context, volume mountedVolume) error {
 	usage, err := getFilesystemUsage(volume)
 	if err != nil {
 		return err
 	}
 
-	if usage < threshold {
+	projectedToFill := false
+	if mode == "predictive" {
+		projectedToFill, err = checkPredictiveTrend(volume)
+		if err != nil {
+			return err
+		}
+	}
+
+	if usage < threshold && !projectedToFill {
 		return nil
 	}
 
-	log.Printf("volume %s has passed pressure threshold of %d%% usage", volume.Name, threshold)
+	if projectedToFill {
+		log.Printf("volume %s: projected to fill within lead time of %s", volume.Name, predictiveLeadTime)
+	} else {
+		log.Printf("volume %s has passed pressure threshold of %d%% usage", volume.Name, threshold)
+	}
 	log.Printf("volume %s: attempting to resize filesystem to partition size", volume.Name)
 
 	err = resizeFilesystem(volume)
@@ -186,8 +253,8 @@ func checkFilesystemUsage(volume mountedGCEVolume) error {
 		return nil
 	}
 
-	log.Printf("volume %s: attempting to resize persistent disk to %d%%", volume.Name, 100+expandBy)
-	err = resizePersistentDisk(volume)
+	log.Printf("volume %s: attempting to resize %s disk to %d%%", volume.Name, volume.Ref.Provider, 100+expandBy)
+	err = resizeDisk(ctx, volume)
 	if err != nil {
 		return err
 	}
@@ -210,84 +277,88 @@ func checkFilesystemUsage(volume mountedGCEVolume) error {
 		return nil
 	}
 
+	budgetFor(volume.key()).bump()
 	return fmt.Errorf("failed to relieve pressure on persistent disk")
 }
 
-func resizePersistentDisk(volume mountedGCEVolume) error {
-	log.Printf("DisksService: attempting to get PD %s in zone %s project %s", volume.PDName, volume.GCPZone, projectID)
-	disk, err := diskService.Get(projectID, volume.GCPZone, volume.PDName).Do()
+// resizeDisk grows volume's underlying disk by expandBy percent, computing
+// the target size from the volume's current size rather than querying the
+// cloud API for it, since DiskProvider deliberately exposes nothing beyond
+// Resolve and Resize. It respects the configured rate limits before
+// attempting anything, and the configured size cap on the target it picks.
+func resizeDisk(ctx context.Context, volume mountedVolume) error {
+	allowed, retryAfter, err := checkExpansionBudget(volume)
 	if err != nil {
 		return err
 	}
-
-	log.Printf("DisksService: PD %s is %dGb in size", volume.PDName, disk.SizeGb)
-
-	// Grow by at least 1GB
-	expand := math.Max(1, float64(disk.SizeGb)*(float64(expandBy)/100.0))
-	newSize := disk.SizeGb + int64(math.Ceil(expand))
-
-	log.Printf("DisksService: attempting to resize PD %s from %dGb to %dGb", volume.PDName, disk.SizeGb, newSize)
-
-	resizeReq := google_compute.DisksResizeRequest{
-		SizeGb: newSize,
+	if !allowed {
+		return fmt.Errorf("volume %s: expansion budget exhausted, retry in %s", volume.Name, retryAfter.Round(time.Second))
 	}
-	op, err := diskService.Resize(projectID, volume.GCPZone, volume.PDName, &resizeReq).Do()
+
+	currentSizeGb, err := currentVolumeSizeGb(volume)
 	if err != nil {
 		return err
 	}
 
-	if op == nil {
-		return fmt.Errorf("nil operation returned by GCPDisksService")
+	if maxSizeGb > 0 && currentSizeGb >= maxSizeGb {
+		return fmt.Errorf("volume %s: already at configured max size of %dGb", volume.Name, maxSizeGb)
 	}
 
-	for op.Status != "DONE" || op.Error != nil {
-		time.Sleep(30 * time.Second)
-
-		op, err = zoneOperationsService.Get(projectID, volume.GCPZone, op.Name).Do()
-		if err != nil {
-			return err
-		}
+	// Grow by at least 1GB
+	expand := math.Max(1, float64(currentSizeGb)*(float64(expandBy)/100.0))
+	newSizeGb := currentSizeGb + int64(math.Ceil(expand))
 
-		if op == nil {
-			return fmt.Errorf("nil operation returned by GCPZoneOperationsService")
-		}
+	if maxSizeGb > 0 && newSizeGb > maxSizeGb {
+		newSizeGb = maxSizeGb
 	}
 
-	if op.Error != nil {
-		merr := &multierror.Error{}
-		for _, v := range op.Error.Errors {
-			if v == nil {
-				continue
-			}
+	log.Printf("volume %s: attempting to resize %s disk %s from ~%dGb to %dGb", volume.Name, volume.Ref.Provider, volume.Ref.VolumeID, currentSizeGb, newSizeGb)
 
-			merr = multierror.Append(merr, errors.New(v.Message))
-		}
+	start := time.Now()
+	err = volume.Provider.Resize(ctx, volume.Ref, newSizeGb)
+	pdResizeDuration.WithLabelValues(volume.key()).Observe(time.Since(start).Seconds())
+
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	pdResizeTotal.WithLabelValues(volume.key(), result).Inc()
 
-		return multierror.Flatten(merr)
+	if err != nil {
+		recordExpansionFailure(volume, err)
+		recordPodEvent(core_v1.EventTypeWarning, "ExpansionFailed", fmt.Sprintf("volume %s: failed to resize %s disk: %v", volume.Name, volume.Ref.Provider, err))
+		return err
 	}
 
-	log.Printf("DisksService: PD %s resized", volume.PDName)
+	recordExpansionSuccess(volume)
+	lastExpansionTimestamp.WithLabelValues(volume.key()).SetToCurrentTime()
+	recordPodEvent(core_v1.EventTypeNormal, "PersistentDiskResized", fmt.Sprintf("volume %s: %s disk resized to %dGb", volume.Name, volume.Ref.Provider, newSizeGb))
+
 	return nil
 }
 
-func resizeFilesystem(volume mountedGCEVolume) error {
-	cmd := exec.Command("resize2fs", volume.DevicePath)
-	if cmd == nil {
-		return fmt.Errorf("could not start resize2fs")
-	}
+// currentVolumeSizeGb reports volume's current size, read from the block
+// device directly for volumeMode: Block volumes and from the mounted
+// filesystem otherwise.
+func currentVolumeSizeGb(volume mountedVolume) (int64, error) {
+	if volume.VolumeMode == core_v1.PersistentVolumeBlock {
+		sizeBytes, err := blockDeviceSizeBytes(volume.DevicePath)
+		if err != nil {
+			return 0, err
+		}
 
-	var out bytes.Buffer
-	cmd.Stdout = &out
+		return int64(math.Ceil(float64(sizeBytes) / (1024 * 1024 * 1024))), nil
+	}
 
-	err := cmd.Run()
-	if err != nil {
-		return err
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(volume.MountedPath, &stat); err != nil {
+		return 0, err
 	}
 
-	return nil
+	return int64(math.Ceil(float64(stat.Blocks*uint64(stat.Bsize)) / (1024 * 1024 * 1024))), nil
 }
 
-func getFilesystemUsage(volume mountedGCEVolume) (int, error) {
+func getFilesystemUsage(volume mountedVolume) (int, error) {
 	stat := syscall.Statfs_t{}
 	err := syscall.Statfs(volume.MountedPath, &stat)
 	if err != nil {
@@ -295,30 +366,31 @@ func getFilesystemUsage(volume mountedGCEVolume) (int, error) {
 	}
 
 	usage := int((1.0 - (float64(stat.Bavail) / float64(stat.Blocks))) * 100.0)
+
+	volumeUsageRatio.WithLabelValues(volume.key()).Set(float64(usage) / 100.0)
+	volumeSizeBytes.WithLabelValues(volume.key()).Set(float64(stat.Blocks) * float64(stat.Bsize))
+
 	return usage, nil
 }
 
-func getMountedVolumes(pod *core_v1.Pod, container *core_v1.Container, volumes []string, clientset *kubernetes.Clientset) ([]mountedGCEVolume, error) {
-	gceVolumes := make([]mountedGCEVolume, len(volumes))
+func getMountedVolumes(pod *core_v1.Pod, container *core_v1.Container, volumes []string, clientset *kubernetes.Clientset, cloudProvider, csiProvider DiskProvider) ([]mountedVolume, error) {
+	mountedVolumes := make([]mountedVolume, 0, len(volumes))
 
 	mappedVolumeMounts := mapVolumeMounts(container.VolumeMounts)
+	mappedVolumeDevices := mapVolumeDevices(container.VolumeDevices)
 	mappedVolumes := mapVolumes(pod.Spec.Volumes)
 	for _, volumeName := range volumes {
 		volume, ok := mappedVolumes[volumeName]
 		if ok == false {
 			return nil, fmt.Errorf("volume %s does not exist in pod %s", volumeName, podName)
 		}
-		volumeMount, ok := mappedVolumeMounts[volumeName]
-		if ok == false {
-			return nil, fmt.Errorf("volume %s is not mounted to container %s", volumeName, containerName)
-		}
 
-		if volume.GCEPersistentDisk != nil {
+		if volume.GCEPersistentDisk != nil || volume.AWSElasticBlockStore != nil || volume.AzureDisk != nil {
 			return nil, fmt.Errorf("volume %s cannot be a short-hand bound persistent volume, must use PersistentVolumeClaim", volumeName)
 		}
 
 		if volume.PersistentVolumeClaim == nil {
-			return nil, fmt.Errorf("volume %s is not a GCEPersistentDisk", volumeName)
+			return nil, fmt.Errorf("volume %s is not backed by a PersistentVolumeClaim", volumeName)
 		}
 
 		pvcName := volume.PersistentVolumeClaim.ClaimName
@@ -353,58 +425,80 @@ func getMountedVolumes(pod *core_v1.Pod, container *core_v1.Container, volumes [
 			return nil, fmt.Errorf("volume %s: PV %s phase is not Bound, instead %s", volumeName, pvName, pv.Status.Phase)
 		}
 
-		pd := pv.Spec.GCEPersistentDisk
-		if pd == nil {
-			return nil, fmt.Errorf("volume %s: PV %s is not a GCEPersistentDisk", volumeName, pvName)
-		}
-
-		if pv.Labels == nil {
-			return nil, fmt.Errorf("volume %s: PV %s is missing labels", volumeName, pvName)
+		provider, err := selectProvider(clientset, cloudProvider, csiProvider, pv)
+		if err != nil {
+			return nil, fmt.Errorf("volume %s: %v", volumeName, err)
 		}
 
-		regionLabel, ok := pv.Labels["failure-domain.beta.kubernetes.io/region"]
-		if ok == false {
-			return nil, fmt.Errorf("volume %s: PV %s missing failure-domain.beta.kubernetes.io/region label", volumeName, pvName)
+		ref, err := provider.Resolve(pv)
+		if err != nil {
+			return nil, fmt.Errorf("volume %s: %v", volumeName, err)
 		}
 
-		zoneLabel, ok := pv.Labels["failure-domain.beta.kubernetes.io/zone"]
-		if ok == false {
-			return nil, fmt.Errorf("volume %s: PV %s missing failure-domain.beta.kubernetes.io/zone label", volumeName, pvName)
+		volumeMode := core_v1.PersistentVolumeFilesystem
+		if pv.Spec.VolumeMode != nil {
+			volumeMode = *pv.Spec.VolumeMode
 		}
 
-		if pd.Partition != 0 {
-			return nil, fmt.Errorf("volume %s: PD %s has more than one parition", volumeName, pd.PDName)
-		}
+		if volumeMode == core_v1.PersistentVolumeBlock {
+			device, ok := mappedVolumeDevices[volumeName]
+			if ok == false {
+				return nil, fmt.Errorf("volume %s is not attached as a block device to container %s", volumeName, containerName)
+			}
 
-		if pd.ReadOnly == true {
-			return nil, fmt.Errorf("volume %s: PD %s is read only", volumeName, pd.PDName)
+			mountedVolumes = append(mountedVolumes, mountedVolume{
+				Name:         volumeName,
+				DevicePath:   device.DevicePath,
+				VolumeMode:   volumeMode,
+				PVCNamespace: pvc.Namespace,
+				PVCName:      pvc.Name,
+				Ref:          ref,
+				Provider:     provider,
+			})
+			continue
 		}
 
-		if pd.FSType != "" && pd.FSType != "ext4" {
-			return nil, fmt.Errorf("volume %s: PD %s is not a ext4 volume", volumeName, pd.PDName)
+		volumeMount, ok := mappedVolumeMounts[volumeName]
+		if ok == false {
+			return nil, fmt.Errorf("volume %s is not mounted to container %s", volumeName, containerName)
 		}
 
 		log.Printf("attempting to resolve device path for %s", volumeMount.MountPath)
 		devicePath, err := resolveDevicePath(volumeMount.MountPath)
 		if err != nil {
-			return nil, nil
+			return nil, err
 		}
 
 		if devicePath == "" {
 			return nil, fmt.Errorf("could not resolve device path for volume %s", volumeName)
 		}
 
-		gceVolumes = append(gceVolumes, mountedGCEVolume{
-			Name:        volumeName,
-			MountedPath: volumeMount.MountPath,
-			DevicePath:  devicePath,
-			PDName:      pd.PDName,
-			GCPRegion:   regionLabel,
-			GCPZone:     zoneLabel,
+		fsType := declaredFSType(pv)
+		if fsType == "" {
+			fsType, err = detectFilesystemType(devicePath)
+			if err != nil {
+				return nil, fmt.Errorf("volume %s: could not detect filesystem type: %v", volumeName, err)
+			}
+		}
+
+		if !isSupportedFSType(fsType) {
+			return nil, fmt.Errorf("volume %s: unsupported filesystem type %q", volumeName, fsType)
+		}
+
+		mountedVolumes = append(mountedVolumes, mountedVolume{
+			Name:         volumeName,
+			MountedPath:  volumeMount.MountPath,
+			DevicePath:   devicePath,
+			FSType:       fsType,
+			VolumeMode:   volumeMode,
+			PVCNamespace: pvc.Namespace,
+			PVCName:      pvc.Name,
+			Ref:          ref,
+			Provider:     provider,
 		})
 	}
 
-	return gceVolumes, nil
+	return mountedVolumes, nil
 }
 
 func resolveDevicePath(mountPath string) (string, error) {
@@ -445,6 +539,14 @@ func mapVolumes(volumes []core_v1.Volume) map[string]core_v1.Volume {
 	return vm
 }
 
+func mapVolumeDevices(volumeDevices []core_v1.VolumeDevice) map[string]core_v1.VolumeDevice {
+	vd := make(map[string]core_v1.VolumeDevice)
+	for _, v := range volumeDevices {
+		vd[v.Name] = v
+	}
+	return vd
+}
+
 func findContainer(pod *core_v1.Pod, name string) (*core_v1.Container, error) {
 	if pod == nil {
 		return nil, errors.New("pod is nil")
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	volumeUsageRatio = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sidecar_volume_usage_ratio",
+		Help: "Fraction of each managed volume's filesystem currently in use.",
+	}, []string{"volume"})
+
+	volumeSizeBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sidecar_volume_size_bytes",
+		Help: "Current size in bytes of each managed volume.",
+	}, []string{"volume"})
+
+	fsResizeTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sidecar_fs_resize_total",
+		Help: "Count of filesystem resize attempts, by result.",
+	}, []string{"volume", "result"})
+
+	pdResizeTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "sidecar_pd_resize_total",
+		Help: "Count of persistent disk resize attempts, by result.",
+	}, []string{"volume", "result"})
+
+	pdResizeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "sidecar_pd_resize_duration_seconds",
+		Help:    "Time taken for persistent disk resize operations to complete.",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 10),
+	}, []string{"volume"})
+
+	lastExpansionTimestamp = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "sidecar_last_expansion_timestamp",
+		Help: "Unix timestamp of the last successful expansion of each volume.",
+	}, []string{"volume"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		volumeUsageRatio,
+		volumeSizeBytes,
+		fsResizeTotal,
+		pdResizeTotal,
+		pdResizeDuration,
+		lastExpansionTimestamp,
+	)
+}
+
+// serveMetrics starts the Prometheus metrics HTTP server on addr and blocks
+// for the lifetime of the process.
+func serveMetrics(addr string) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Printf("metrics: listening on %s", addr)
+	log.Fatal(http.ListenAndServe(addr, mux))
+}
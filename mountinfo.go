@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// findContainerPID returns the PID, as seen from the host PID namespace, of
+// the container identified by containerID (e.g. "containerd://abcd...").
+// It scans /proc for a process whose cgroup membership references the
+// container's ID, the same trick tools like crictl use when pulling in a
+// full CRI client isn't worth it for a single lookup.
+func findContainerPID(containerID string) (int, error) {
+	id := containerID
+	if idx := strings.Index(id, "://"); idx != -1 {
+		id = id[idx+len("://"):]
+	}
+	if id == "" {
+		return 0, fmt.Errorf("container has no ID reported yet")
+	}
+
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return 0, err
+	}
+
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+
+		cgroup, err := os.ReadFile(filepath.Join("/proc", entry.Name(), "cgroup"))
+		if err != nil {
+			continue // process exited between ReadDir and here, or isn't ours to read
+		}
+
+		if strings.Contains(string(cgroup), id) {
+			return pid, nil
+		}
+	}
+
+	return 0, fmt.Errorf("no process found for container %s", containerID)
+}
+
+// deviceForMountPath reads pid's mountinfo, which reflects its own mount
+// namespace regardless of the namespace the reader is in, and returns the
+// host-visible source device mounted at mountPath inside it. Reading the
+// file this way resolves the device node without needing to spawn nsenter,
+// but that's all it gives us: mountPath itself is a path in pid's own mount
+// namespace, not a path this process can open, Statfs or exec against — use
+// hostMountPath for that.
+func deviceForMountPath(pid int, mountPath string) (string, error) {
+	data, err := os.ReadFile(fmt.Sprintf("/proc/%d/mountinfo", pid))
+	if err != nil {
+		return "", err
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 10 {
+			continue
+		}
+
+		// Fields: mount-id parent-id major:minor root mount-point options
+		// [optional-fields] - fstype source super-options
+		if fields[4] != mountPath {
+			continue
+		}
+
+		dash := -1
+		for i, f := range fields {
+			if f == "-" {
+				dash = i
+				break
+			}
+		}
+		if dash == -1 || dash+2 >= len(fields) {
+			continue
+		}
+
+		return fields[dash+2], nil
+	}
+
+	return "", fmt.Errorf("mount point %s not found in pid %d's mountinfo", mountPath, pid)
+}
+
+// hostMountPath translates mountPath, a path as seen inside pid's own mount
+// namespace, into a path this process (reading from the host/root mount
+// namespace) can actually Statfs or pass to resize2fs/xfs_growfs/btrfs.
+// /proc/<pid>/root is a magic symlink to pid's filesystem root as seen from
+// its own namespace, so joining it with mountPath reaches the same file pid
+// sees, without this process needing to be nsenter'd into pid's mount
+// namespace itself.
+func hostMountPath(pid int, mountPath string) string {
+	return filepath.Join(fmt.Sprintf("/proc/%d/root", pid), mountPath)
+}
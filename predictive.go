@@ -0,0 +1,143 @@
+package main
+
+import (
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	mode               string
+	predictiveLeadTime time.Duration
+	predictiveSamples  int
+)
+
+// predictiveTrend keeps a fixed-size ring buffer of (timestamp, free bytes)
+// samples for one volume, plus the rolling sum(x), sum(y), sum(xy), sum(x²)
+// needed to fit a linear regression of free bytes over time in O(1) per
+// update rather than recomputing over the whole buffer.
+type predictiveTrend struct {
+	mu      sync.Mutex
+	samples []trendSample
+	next    int
+	full    bool
+
+	sumX, sumY, sumXY, sumX2 float64
+}
+
+type trendSample struct {
+	x float64 // unix seconds
+	y float64 // free bytes
+}
+
+func newPredictiveTrend(size int) *predictiveTrend {
+	return &predictiveTrend{samples: make([]trendSample, size)}
+}
+
+func (t *predictiveTrend) add(now time.Time, freeBytes float64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.full {
+		old := t.samples[t.next]
+		t.sumX -= old.x
+		t.sumY -= old.y
+		t.sumXY -= old.x * old.y
+		t.sumX2 -= old.x * old.x
+	}
+
+	sample := trendSample{x: float64(now.Unix()), y: freeBytes}
+	t.samples[t.next] = sample
+	t.sumX += sample.x
+	t.sumY += sample.y
+	t.sumXY += sample.x * sample.y
+	t.sumX2 += sample.x * sample.x
+
+	t.next++
+	if t.next >= len(t.samples) {
+		t.next = 0
+		t.full = true
+	}
+}
+
+// slope returns the linear-regression slope of free bytes over time in
+// bytes/sec, and whether the buffer is full enough to trust it.
+func (t *predictiveTrend) slope() (float64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if !t.full {
+		return 0, false
+	}
+
+	n := float64(len(t.samples))
+	denom := n*t.sumX2 - t.sumX*t.sumX
+	if denom == 0 {
+		return 0, false
+	}
+
+	return (n*t.sumXY - t.sumX*t.sumY) / denom, true
+}
+
+// timeToFull projects how long until free space hits zero at the current
+// slope. It reports false when the trend isn't usable: the buffer isn't
+// full yet, or free space isn't shrinking.
+func (t *predictiveTrend) timeToFull(currentFreeBytes float64) (time.Duration, bool) {
+	slope, ok := t.slope()
+	if !ok || slope >= 0 {
+		return 0, false
+	}
+
+	secondsToFull := -currentFreeBytes / slope
+	return time.Duration(secondsToFull * float64(time.Second)), true
+}
+
+var volumeTrends = struct {
+	mu sync.Mutex
+	m  map[string]*predictiveTrend
+}{m: make(map[string]*predictiveTrend)}
+
+func trendFor(volumeName string) *predictiveTrend {
+	volumeTrends.mu.Lock()
+	defer volumeTrends.mu.Unlock()
+
+	t, ok := volumeTrends.m[volumeName]
+	if !ok {
+		t = newPredictiveTrend(predictiveSamples)
+		volumeTrends.m[volumeName] = t
+	}
+	return t
+}
+
+// getFilesystemFreeBytes reads volume's current free space for the
+// predictive mode's regression.
+func getFilesystemFreeBytes(volume mountedVolume) (float64, error) {
+	stat := syscall.Statfs_t{}
+	if err := syscall.Statfs(volume.MountedPath, &stat); err != nil {
+		return 0, err
+	}
+
+	return float64(stat.Bavail) * float64(stat.Bsize), nil
+}
+
+// checkPredictiveTrend records a new free-space sample for volume and
+// reports whether its projected time-to-full has fallen under
+// predictiveLeadTime. It falls back to false (deferring to the static
+// threshold check) until the sample buffer fills or while free space isn't
+// trending down.
+func checkPredictiveTrend(volume mountedVolume) (bool, error) {
+	freeBytes, err := getFilesystemFreeBytes(volume)
+	if err != nil {
+		return false, err
+	}
+
+	trend := trendFor(volume.key())
+	trend.add(time.Now(), freeBytes)
+
+	ttf, ok := trend.timeToFull(freeBytes)
+	if !ok {
+		return false, nil
+	}
+
+	return ttf < predictiveLeadTime, nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// DiskRef identifies a single cloud disk (or CSI volume) backing a
+// PersistentVolume, carrying whatever addressing information the owning
+// DiskProvider's Resize needs to act on it.
+type DiskRef struct {
+	Provider string
+	VolumeID string
+	Zone     string
+	Region   string
+
+	// PVCNamespace/PVCName are only set for CSI-backed volumes, where
+	// Resize works by patching the PVC rather than calling a cloud API.
+	PVCNamespace string
+	PVCName      string
+}
+
+// DiskProvider resolves a PersistentVolume down to the disk it is backed
+// by, and knows how to grow that disk. Each cloud gets its own
+// implementation; CSI-backed volumes are handled by csiDiskProvider
+// regardless of the underlying cloud.
+type DiskProvider interface {
+	// Resolve returns the DiskRef for a PersistentVolume this provider
+	// understands, or an error if the PV isn't one of its volume types.
+	Resolve(pv *core_v1.PersistentVolume) (DiskRef, error)
+	// Resize grows the disk identified by ref to at least newSizeGb.
+	Resize(ctx context.Context, ref DiskRef, newSizeGb int64) error
+}
+
+// cloudProviderForNode picks the DiskProvider matching the cloud the node
+// is running on, based on the scheme of its ProviderID (e.g. "gce://",
+// "aws://", "azure://"). This provider is used for any PV whose volume
+// source is that cloud's native disk type.
+func cloudProviderForNode(ctx context.Context, uri *url.URL) (DiskProvider, error) {
+	switch uri.Scheme {
+	case "gce":
+		return newGCEDiskProvider(ctx, uri.Host)
+	case "aws":
+		return newAWSDiskProvider(awsRegionFromProviderID(uri))
+	case "azure":
+		return newAzureDiskProvider(azureSubscriptionID, azureResourceGroup)
+	default:
+		return nil, fmt.Errorf("unsupported cloud provider scheme %q in node ProviderID %q", uri.Scheme, uri.String())
+	}
+}
+
+// selectProvider picks the DiskProvider that should handle pv: the CSI
+// provider if pv is CSI-backed and its StorageClass allows expansion,
+// otherwise the cloud provider matching pv's native volume source.
+func selectProvider(clientset *kubernetes.Clientset, cloudProvider, csiProvider DiskProvider, pv *core_v1.PersistentVolume) (DiskProvider, error) {
+	if pv.Spec.CSI != nil {
+		expandable, err := csiExpansionAllowed(clientset, pv)
+		if err != nil {
+			return nil, err
+		}
+		if !expandable {
+			return nil, fmt.Errorf("PV %s: CSI driver %s does not allow volume expansion", pv.Name, pv.Spec.CSI.Driver)
+		}
+		return csiProvider, nil
+	}
+
+	switch {
+	case pv.Spec.GCEPersistentDisk != nil, pv.Spec.AWSElasticBlockStore != nil, pv.Spec.AzureDisk != nil:
+		return cloudProvider, nil
+	default:
+		return nil, fmt.Errorf("PV %s: no DiskProvider recognises this volume type", pv.Name)
+	}
+}
+
+// csiExpansionAllowed reports whether pv's StorageClass sets
+// allowVolumeExpansion: true.
+func csiExpansionAllowed(clientset *kubernetes.Clientset, pv *core_v1.PersistentVolume) (bool, error) {
+	if pv.Spec.StorageClassName == "" {
+		return false, nil
+	}
+
+	sc, err := clientset.Storage().StorageClasses().Get(pv.Spec.StorageClassName, meta_v1.GetOptions{})
+	if err != nil {
+		return false, err
+	}
+
+	return sc.AllowVolumeExpansion != nil && *sc.AllowVolumeExpansion, nil
+}
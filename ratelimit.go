@@ -0,0 +1,224 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"google.golang.org/api/googleapi"
+
+	core_v1 "k8s.io/api/core/v1"
+	meta_v1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	lastExpansionAnnotation  = "sidecar.hpidcock.io/last-expansion"
+	expansionCountAnnotation = "sidecar.hpidcock.io/expansion-count"
+
+	maxBackoff = 30 * time.Minute
+)
+
+var (
+	maxSizeGb            int64
+	minExpansionInterval time.Duration
+	maxExpansionsPerDay  int
+)
+
+// expansionBudget tracks the exponential backoff applied to one volume
+// after a failed expansion (quota exceeded, or usage still over threshold
+// post-resize). It's in-memory only and resets on sidecar restart; the
+// longer-lived per-day/per-interval budget lives in PVC annotations
+// instead, via recordExpansionSuccess.
+type expansionBudget struct {
+	mu      sync.Mutex
+	backoff time.Duration
+}
+
+func (b *expansionBudget) bump() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.backoff == 0 {
+		b.backoff = minExpansionInterval
+		if b.backoff == 0 {
+			b.backoff = time.Minute
+		}
+	} else {
+		b.backoff *= 2
+	}
+
+	if b.backoff > maxBackoff {
+		b.backoff = maxBackoff
+	}
+}
+
+func (b *expansionBudget) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.backoff = 0
+}
+
+func (b *expansionBudget) current() time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.backoff
+}
+
+var expansionBudgets = struct {
+	mu        sync.Mutex
+	perVolume map[string]*expansionBudget
+}{perVolume: make(map[string]*expansionBudget)}
+
+func budgetFor(volumeName string) *expansionBudget {
+	expansionBudgets.mu.Lock()
+	defer expansionBudgets.mu.Unlock()
+
+	b, ok := expansionBudgets.perVolume[volumeName]
+	if !ok {
+		b = &expansionBudget{}
+		expansionBudgets.perVolume[volumeName] = b
+	}
+	return b
+}
+
+// checkExpansionBudget reports whether volume is allowed to expand right
+// now, consulting both the in-memory backoff and the longer-lived budget
+// persisted on its PVC's annotations. If not allowed, it also returns how
+// long the caller should wait before trying again.
+func checkExpansionBudget(volume mountedVolume) (bool, time.Duration, error) {
+	pvc, err := getPVC(volume)
+	if err != nil {
+		return false, 0, err
+	}
+
+	now := time.Now()
+
+	wait := minExpansionInterval
+	if backoff := budgetFor(volume.key()).current(); backoff > wait {
+		wait = backoff
+	}
+
+	if last, ok := pvc.Annotations[lastExpansionAnnotation]; ok {
+		lastTime, err := time.Parse(time.RFC3339, last)
+		if err == nil {
+			if elapsed := now.Sub(lastTime); elapsed < wait {
+				return false, wait - elapsed, nil
+			}
+		}
+	}
+
+	if maxExpansionsPerDay > 0 {
+		recent := recentExpansions(pvc, now)
+		if len(recent) >= maxExpansionsPerDay {
+			return false, recent[0].Add(24 * time.Hour).Sub(now), nil
+		}
+	}
+
+	return true, 0, nil
+}
+
+// recordExpansionSuccess clears volume's backoff and persists the
+// expansion onto its PVC's annotations, so the per-day budget survives a
+// sidecar restart.
+func recordExpansionSuccess(volume mountedVolume) {
+	budgetFor(volume.key()).reset()
+
+	pvc, err := getPVC(volume)
+	if err != nil {
+		log.Printf("volume %s: could not read PVC to persist expansion budget: %v", volume.Name, err)
+		return
+	}
+
+	now := time.Now()
+	recent := append(recentExpansions(pvc, now), now)
+
+	if pvc.Annotations == nil {
+		pvc.Annotations = map[string]string{}
+	}
+	pvc.Annotations[lastExpansionAnnotation] = now.Format(time.RFC3339)
+	pvc.Annotations[expansionCountAnnotation] = encodeExpansions(recent)
+
+	if _, err := eventsClientset.Core().PersistentVolumeClaims(volume.PVCNamespace).Update(pvc); err != nil {
+		log.Printf("volume %s: could not persist expansion budget: %v", volume.Name, err)
+	}
+}
+
+// recordExpansionFailure bumps volume's backoff when err looks like a
+// quota-exceeded or rate-limited error from the cloud provider.
+func recordExpansionFailure(volume mountedVolume, err error) {
+	if isThrottled(err) {
+		budgetFor(volume.key()).bump()
+	}
+}
+
+// isThrottled reports whether err is the cloud provider telling us to slow
+// down: a quota was exceeded, or the API call itself was rate limited.
+// Each provider's SDK surfaces this as its own error type with its own
+// wording, so check those before falling back to a generic substring match
+// (which also catches errors that have already been flattened to plain
+// text, such as a GCE operation error).
+func isThrottled(err error) bool {
+	if awsErr, ok := err.(awserr.Error); ok {
+		switch awsErr.Code() {
+		case "VolumeModificationRateExceeded", "RequestLimitExceeded":
+			return true
+		}
+	}
+
+	if azureErr, ok := err.(autorest.DetailedError); ok {
+		if azureErr.Response != nil && azureErr.Response.StatusCode == http.StatusTooManyRequests {
+			return true
+		}
+	}
+
+	if gerr, ok := err.(*googleapi.Error); ok && gerr.Code == http.StatusForbidden {
+		for _, e := range gerr.Errors {
+			if strings.Contains(strings.ToLower(e.Reason), "quota") {
+				return true
+			}
+		}
+	}
+
+	msg := strings.ToLower(err.Error())
+	return strings.Contains(msg, "quota") ||
+		strings.Contains(msg, "toomanyrequests") ||
+		strings.Contains(msg, "requestratetoolarge") ||
+		strings.Contains(msg, "maximum modification rate")
+}
+
+func getPVC(volume mountedVolume) (*core_v1.PersistentVolumeClaim, error) {
+	return eventsClientset.Core().PersistentVolumeClaims(volume.PVCNamespace).Get(volume.PVCName, meta_v1.GetOptions{})
+}
+
+// recentExpansions returns the expansion timestamps recorded on pvc that
+// fall within the last 24h of now.
+func recentExpansions(pvc *core_v1.PersistentVolumeClaim, now time.Time) []time.Time {
+	raw, ok := pvc.Annotations[expansionCountAnnotation]
+	if !ok || raw == "" {
+		return nil
+	}
+
+	var kept []time.Time
+	for _, s := range strings.Split(raw, ",") {
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			continue
+		}
+		if now.Sub(t) < 24*time.Hour {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+func encodeExpansions(timestamps []time.Time) string {
+	parts := make([]string, len(timestamps))
+	for i, t := range timestamps {
+		parts[i] = t.Format(time.RFC3339)
+	}
+	return strings.Join(parts, ",")
+}